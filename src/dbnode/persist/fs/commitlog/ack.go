@@ -0,0 +1,99 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import "sync"
+
+// CommitLogAck is returned by CommitLog.WriteAsync and lets a caller confirm
+// durability of a write without blocking the write path, either by waiting
+// synchronously or by selecting on the completion channel (e.g. to await a
+// batch of writes together).
+type CommitLogAck interface {
+	// Wait blocks until the write this ack was issued for has been flushed
+	// (StrategyWriteWait semantics) and returns its result.
+	Wait() error
+	// Done returns a channel that receives the write's result exactly once,
+	// for callers that want to select on multiple pending acks.
+	Done() <-chan error
+}
+
+// commitLogAckPool recycles commitLogAck instances so that WriteAsync does
+// not have to allocate a fresh sync.WaitGroup (and channel) on every call.
+var commitLogAckPool = sync.Pool{
+	New: func() interface{} {
+		return &commitLogAck{done: make(chan error, 1)}
+	},
+}
+
+type commitLogAck struct {
+	wg   sync.WaitGroup
+	err  error
+	done chan error
+}
+
+func newCommitLogAck() *commitLogAck {
+	ack := commitLogAckPool.Get().(*commitLogAck)
+	ack.wg.Add(1)
+	ack.err = nil
+	return ack
+}
+
+// complete is used as the commitLogWrite's completionFn: it is invoked
+// exactly once by the write() goroutine when the write's durability has been
+// determined.
+func (a *commitLogAck) complete(err error) {
+	a.err = err
+	a.done <- err
+	a.wg.Done()
+}
+
+func (a *commitLogAck) Wait() error {
+	a.wg.Wait()
+	err := a.err
+
+	// Safe to recycle here: complete() has already run (wg.Wait returned),
+	// and the only other path back to the pool is discard, which is used
+	// solely for acks that complete() never runs for, so nothing can still
+	// be reading err/done.
+	select {
+	case <-a.done:
+	default:
+	}
+	commitLogAckPool.Put(a)
+
+	return err
+}
+
+func (a *commitLogAck) Done() <-chan error {
+	return a.done
+}
+
+// discard releases an ack that will never be completed, e.g. because
+// enqueueing the write it was created for failed and complete() will
+// therefore never be called. It balances the wg.Add(1) from
+// newCommitLogAck before returning the ack to the pool: putting it back
+// directly would leave the pooled WaitGroup's counter at 1, so the next
+// caller to pull it from the pool would call Add(1) again (counter 2) but
+// only ever receive one completion, hanging Wait() forever.
+func (a *commitLogAck) discard() {
+	a.wg.Done()
+	commitLogAckPool.Put(a)
+}
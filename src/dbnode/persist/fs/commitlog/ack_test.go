@@ -0,0 +1,114 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCommitLogAckWaitReturnsCompletionError(t *testing.T) {
+	ack := newCommitLogAck()
+	wantErr := errors.New("write failed")
+	ack.complete(wantErr)
+
+	if err := ack.Wait(); err != wantErr {
+		t.Fatalf("expected Wait to return %v, got %v", wantErr, err)
+	}
+}
+
+func TestCommitLogAckDone(t *testing.T) {
+	ack := newCommitLogAck()
+	ack.complete(nil)
+
+	select {
+	case err := <-ack.Done():
+		if err != nil {
+			t.Fatalf("expected nil error on Done channel, got %v", err)
+		}
+	default:
+		t.Fatal("expected Done channel to have a value ready after complete")
+	}
+}
+
+// TestCommitLogAckDiscardDoesNotCorruptPool is a regression test for the
+// pooled WaitGroup imbalance: discard must balance the wg.Add(1) done by
+// newCommitLogAck so that an ack recycled via discard (rather than Wait) is
+// fully usable, with its WaitGroup counter back at zero, the next time it is
+// pulled from the pool. Before the fix, discard just called
+// commitLogAckPool.Put directly, leaving the counter at 1 so the next
+// acquirer's wg.Add(1) (counter 2) was never balanced by a single complete(),
+// hanging that acquirer's Wait() forever.
+func TestCommitLogAckDiscardDoesNotCorruptPool(t *testing.T) {
+	const iterations = 1000
+
+	for i := 0; i < iterations; i++ {
+		discarded := newCommitLogAck()
+		discarded.discard()
+
+		ack := newCommitLogAck()
+		ack.complete(nil)
+
+		done := make(chan error, 1)
+		go func() { done <- ack.Wait() }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("iteration %d: expected nil error, got %v", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("iteration %d: Wait() hung, pooled WaitGroup is corrupted", i)
+		}
+	}
+}
+
+// TestCommitLogAckConcurrentDiscardAndComplete exercises newCommitLogAck,
+// complete, Wait and discard concurrently from many goroutines under -race
+// to catch both data races on the pooled commitLogAck and any WaitGroup
+// misuse (e.g. Add after Wait has already returned) that a single-threaded
+// test wouldn't reach.
+func TestCommitLogAckConcurrentDiscardAndComplete(t *testing.T) {
+	const numGoroutines = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if i%2 == 0 {
+				ack := newCommitLogAck()
+				ack.discard()
+				return
+			}
+
+			ack := newCommitLogAck()
+			ack.complete(nil)
+			if err := ack.Wait(); err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
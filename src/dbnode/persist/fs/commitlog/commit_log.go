@@ -22,7 +22,9 @@ package commitlog
 
 import (
 	"errors"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/m3db/m3/src/dbnode/clock"
@@ -44,6 +46,46 @@ var (
 	timeZero = time.Time{}
 )
 
+// StrategyWriteWaitUnbounded and StrategyWriteBehindUnbounded queue writes
+// onto an unbounded chain of batches (see unboundedWriteQueue) bounded only
+// by Options.MaxQueuedBytes(), rather than a fixed-size queue that rejects
+// writes outright once full. This trades a bounded backlog for tolerance of
+// transient fsync stalls: a caller only blocks (wait variant) or receives
+// ErrCommitLogQueueFull (behind variant) once the memory cap is actually
+// exceeded, rather than as soon as a fixed number of slots fill up.
+const (
+	StrategyWriteWaitUnbounded Strategy = iota + strategyUnboundedOffset
+	StrategyWriteBehindUnbounded
+)
+
+// strategyUnboundedOffset separates the unbounded strategy values from
+// StrategyWriteWait/StrategyWriteBehind so that adding the unbounded
+// variants here can't collide with the existing iota sequence.
+const strategyUnboundedOffset = 100
+
+// writeQueue abstracts over the fixed-capacity shardedWriteQueue and the
+// unboundedWriteQueue so that write(), writeWait and writeBehind don't need
+// to know which backlog strategy is configured.
+type writeQueue interface {
+	// tryEnqueue attempts to enqueue write, hashing on series for
+	// implementations that shard. blocking controls whether the call should
+	// block (rather than fail) when the queue has no room, and is only
+	// honored by implementations that support backpressure.
+	tryEnqueue(series Series, write commitLogWrite, blocking bool) bool
+	// drainAll invokes fn for every currently queued write, in enqueue
+	// order per series/chain.
+	drainAll(fn func(commitLogWrite))
+	// wake is signalled whenever a write is enqueued.
+	wake() <-chan struct{}
+	// len and cap report current/maximum queue depth for metrics. cap
+	// returns -1 when the implementation has no fixed capacity.
+	len() int
+	cap() int
+	// bytes reports the approximate queued memory footprint, or 0 for
+	// implementations that don't track it.
+	bytes() int64
+}
+
 type newCommitLogWriterFn func(
 	flushFn flushFn,
 	opts Options,
@@ -92,14 +134,29 @@ type commitLog struct {
 	closedState closedState
 	writerState writerState
 	flushState  flushState
+	// healthState holds the sticky error set once the configured
+	// CommitLogFailurePolicy trips (PolicyStop/PolicyStopCommit), surfaced
+	// via Healthy() and used to short-circuit the write path. It carries its
+	// own lock in addition to the three described above; that lock is only
+	// held inside healthState's helper methods.
+	healthState healthState
 	// Associated with the closedState, but stored separately since
 	// it does not require the closedState lock to be acquired before
 	// being accessed.
 	closeErr chan error
 
-	// TODO(r): replace buffered channel with concurrent striped
-	// circular buffer to avoid central write lock contention.
-	writes          chan commitLogWrite
+	// queue replaces a single central buffered channel. For the bounded
+	// strategies it is a set of per-shard lock-free rings striped by series,
+	// avoiding the write-path contention a single channel's internal lock
+	// would otherwise create under high write fan-in; for the unbounded
+	// strategies it is a single mutex-guarded chain of batches (see
+	// newWriteQueue). closeCh signals the write() goroutine to
+	// drain the queue one last time and exit; flushCh/flushRequested signal
+	// a flush out-of-band instead of via an in-queue sentinel value.
+	queue           writeQueue
+	closeCh         chan struct{}
+	flushCh         chan struct{}
+	flushRequested  int32
 	pendingFlushFns []completionFn
 
 	opts  Options
@@ -148,30 +205,35 @@ type closedState struct {
 type commitLogMetrics struct {
 	queued        tally.Gauge
 	queueCapacity tally.Gauge
+	queueBytes    tally.Gauge
+	stallDuration tally.Timer
 	success       tally.Counter
 	errors        tally.Counter
 	openErrors    tally.Counter
 	closeErrors   tally.Counter
 	flushErrors   tally.Counter
 	flushDone     tally.Counter
-}
 
-type valueType int
+	policyIgnoredErrors tally.Counter
 
-// nolint: varcheck, unused
-const (
-	writeValueType valueType = iota
-	flushValueType
-)
+	statsEnqueueWait tally.Timer
+	statsQueueWait   tally.Timer
+	statsWriteWait   tally.Timer
+	statsFlushWait   tally.Timer
+}
 
 type commitLogWrite struct {
-	valueType valueType
-
 	series       Series
 	datapoint    ts.Datapoint
 	unit         xtime.Unit
 	annotation   ts.Annotation
 	completionFn completionFn
+
+	// stats and enqueuedAt are only set when the caller opted in via
+	// WithStats; stats is nil otherwise and the per-stage timestamps below
+	// are skipped entirely.
+	stats      *CommitStats
+	enqueuedAt time.Time
 }
 
 // NewCommitLog creates a new commit log
@@ -188,22 +250,33 @@ func NewCommitLog(opts Options) (CommitLog, error) {
 		nowFn:                opts.ClockOptions().NowFn(),
 		log:                  iopts.Logger(),
 		newCommitLogWriterFn: newCommitLogWriter,
-		writes:               make(chan commitLogWrite, opts.BacklogQueueSize()),
+		queue:                newWriteQueue(opts),
+		closeCh:              make(chan struct{}),
+		flushCh:              make(chan struct{}, 1),
 		closeErr:             make(chan error),
 		metrics: commitLogMetrics{
 			queued:        scope.Gauge("writes.queued"),
 			queueCapacity: scope.Gauge("writes.queue-capacity"),
+			queueBytes:    scope.Gauge("writes.queue-bytes"),
+			stallDuration: scope.Timer("writes.stall-duration"),
 			success:       scope.Counter("writes.success"),
 			errors:        scope.Counter("writes.errors"),
 			openErrors:    scope.Counter("writes.open-errors"),
 			closeErrors:   scope.Counter("writes.close-errors"),
 			flushErrors:   scope.Counter("writes.flush-errors"),
 			flushDone:     scope.Counter("writes.flush-done"),
+
+			policyIgnoredErrors: scope.Counter("writes.policy-ignored-errors"),
+
+			statsEnqueueWait: scope.Timer("writes.stats-enqueue-wait"),
+			statsQueueWait:   scope.Timer("writes.stats-queue-wait"),
+			statsWriteWait:   scope.Timer("writes.stats-write-wait"),
+			statsFlushWait:   scope.Timer("writes.stats-flush-wait"),
 		},
 	}
 
 	switch opts.Strategy() {
-	case StrategyWriteWait:
+	case StrategyWriteWait, StrategyWriteWaitUnbounded:
 		commitLog.writeFn = commitLog.writeWait
 	default:
 		commitLog.writeFn = commitLog.writeBehind
@@ -212,6 +285,19 @@ func NewCommitLog(opts Options) (CommitLog, error) {
 	return commitLog, nil
 }
 
+// newWriteQueue picks the write queue implementation matching the
+// configured Strategy: the fixed-capacity sharded ring buffer for the
+// bounded strategies, or the unbounded batch chain for the two unbounded
+// variants.
+func newWriteQueue(opts Options) writeQueue {
+	switch opts.Strategy() {
+	case StrategyWriteWaitUnbounded, StrategyWriteBehindUnbounded:
+		return newUnboundedWriteQueue(opts.MaxQueuedBytes())
+	default:
+		return newShardedWriteQueue(runtime.GOMAXPROCS(0), opts.BacklogQueueSize())
+	}
+}
+
 func (l *commitLog) Open() error {
 	l.closedState.Lock()
 	defer l.closedState.Unlock()
@@ -229,13 +315,7 @@ func (l *commitLog) Open() error {
 		return err
 	}
 
-	// NB(r): In the future we can introduce a commit log failure policy
-	// similar to Cassandra's "stop", for example see:
-	// https://github.com/apache/cassandra/blob/6dfc1e7eeba539774784dfd650d3e1de6785c938/conf/cassandra.yaml#L232
-	// Right now it is a large amount of coordination to implement something similar.
-	l.commitLogFailFn = func(err error) {
-		l.log.Fatalf("fatal commit log error: %v", err)
-	}
+	l.commitLogFailFn = l.newCommitLogFailFn()
 
 	// Asynchronously write
 	go l.write()
@@ -249,6 +329,10 @@ func (l *commitLog) Open() error {
 }
 
 func (l *commitLog) ActiveLogs() ([]File, error) {
+	if err := l.healthState.getErr(); err != nil && l.healthState.isStopped() {
+		return nil, err
+	}
+
 	l.closedState.Lock()
 	defer l.closedState.Unlock()
 
@@ -271,8 +355,9 @@ func (l *commitLog) flushEvery(interval time.Duration) {
 	var sleepForOverride time.Duration
 
 	for {
-		l.metrics.queued.Update(float64(len(l.writes)))
-		l.metrics.queueCapacity.Update(float64(cap(l.writes)))
+		l.metrics.queued.Update(float64(l.queue.len()))
+		l.metrics.queueCapacity.Update(float64(l.queue.cap()))
+		l.metrics.queueBytes.Update(float64(l.queue.bytes()))
 
 		sleepFor := interval
 
@@ -297,11 +382,23 @@ func (l *commitLog) flushEvery(interval time.Duration) {
 			return
 		}
 
-		l.writes <- commitLogWrite{valueType: flushValueType}
+		l.requestFlush()
 		l.closedState.RUnlock()
 	}
 }
 
+// requestFlush signals the write() goroutine that a flush is due via an
+// atomic flag and a coalesced semaphore, rather than an in-queue sentinel
+// value, so that a flush request never has to wait behind (or jump ahead of)
+// a shard full of pending writes.
+func (l *commitLog) requestFlush() {
+	atomic.StoreInt32(&l.flushRequested, 1)
+	select {
+	case l.flushCh <- struct{}{}:
+	default:
+	}
+}
+
 func (l *commitLog) write() {
 	// This loop is the only part of the commit log that is allowed to modify (open, close, set to nil)
 	// the writer. As a result, it does not need to synchronize itself when it is using the writer (it
@@ -310,62 +407,126 @@ func (l *commitLog) write() {
 	// writerState.writer or writerState.activeFile). In other words, this function can be thought of
 	// as having an implied read lock at all times that is occasionally upgraded to an exclusive lock
 	// for the purpose of mutating the writerState.
-	for write := range l.writes {
-		// For writes requiring acks add to pending acks
-		if write.completionFn != nil {
-			l.pendingFlushFns = append(l.pendingFlushFns, write.completionFn)
+	for {
+		select {
+		case <-l.queue.wake():
+		case <-l.flushCh:
+		case <-l.closeCh:
+			l.queue.drainAll(l.processWrite)
+			l.writerState.Lock()
+			writer := l.writerState.writer
+			l.writerState.writer = nil
+			l.writerState.Unlock()
+
+			l.closeErr <- writer.Close()
+			return
 		}
 
-		if write.valueType == flushValueType {
+		l.queue.drainAll(l.processWrite)
+
+		if atomic.CompareAndSwapInt32(&l.flushRequested, 1, 0) {
 			// TODO(rartoul): This should probably be replaced with a call to Sync() as the expectation
 			// is that the commitlog will actually FSync the data at regular intervals, whereas Flush
 			// just ensures that the writers buffer flushes to the chunkWriter (creating a new chunk), but
 			// does not guarantee that the O.S isn't still buffering the data. Leaving as is for now as making
 			// this change will require extensive benchmarking in production clusters.
 			l.writerState.writer.Flush()
-			continue
 		}
+	}
+}
 
-		if now := l.nowFn(); !now.Before(l.writerState.writerExpireAt) {
-			l.writerState.Lock()
-			err := l.openWriterWithLock(now)
-			l.writerState.Unlock()
-
-			if err != nil {
-				l.metrics.errors.Inc(1)
-				l.metrics.openErrors.Inc(1)
-				l.log.Errorf("failed to open commit log: %v", err)
-
-				if l.commitLogFailFn != nil {
-					l.commitLogFailFn(err)
-				}
-
-				continue
-			}
-		}
+// processWrite performs a single dequeued write against the underlying
+// writer. It is only ever invoked from the write() goroutine while draining
+// the queue, so it is safe to mutate pendingFlushFns without a lock.
+func (l *commitLog) processWrite(write commitLogWrite) {
+	stats := write.stats
+	if stats != nil {
+		queueWait := l.nowFn().Sub(write.enqueuedAt)
+		stats.QueueWait = queueWait
+		l.metrics.statsQueueWait.Record(queueWait)
+	}
 
-		err := l.writerState.writer.Write(write.series,
-			write.datapoint, write.unit, write.annotation)
+	if now := l.nowFn(); !now.Before(l.writerState.writerExpireAt) {
+		l.writerState.Lock()
+		err := l.openWriterWithLock(now)
+		l.writerState.Unlock()
 
 		if err != nil {
 			l.metrics.errors.Inc(1)
-			l.log.Errorf("failed to write to commit log: %v", err)
+			l.metrics.openErrors.Inc(1)
+			l.log.Errorf("failed to open commit log: %v", err)
 
 			if l.commitLogFailFn != nil {
 				l.commitLogFailFn(err)
 			}
 
-			continue
+			// The write was never attempted, so its own error must be fed
+			// to the completion directly: leaving it queued in
+			// pendingFlushFns would have it fire with whatever error (or
+			// nil) the next unrelated flush happens to produce, reporting
+			// this write as durably committed when it never even reached
+			// the writer.
+			if write.completionFn != nil {
+				write.completionFn(err)
+			}
+
+			return
 		}
-		l.metrics.success.Inc(1)
 	}
 
-	l.writerState.Lock()
-	writer := l.writerState.writer
-	l.writerState.writer = nil
-	l.writerState.Unlock()
+	var writeStart time.Time
+	if stats != nil {
+		writeStart = l.nowFn()
+	}
+
+	err := l.writerState.writer.Write(write.series,
+		write.datapoint, write.unit, write.annotation)
+
+	if stats != nil {
+		writeWait := l.nowFn().Sub(writeStart)
+		stats.WriteWait = writeWait
+		l.metrics.statsWriteWait.Record(writeWait)
+	}
+
+	if err != nil {
+		l.metrics.errors.Inc(1)
+		l.log.Errorf("failed to write to commit log: %v", err)
+
+		if l.commitLogFailFn != nil {
+			l.commitLogFailFn(err)
+		}
+
+		if write.completionFn != nil {
+			write.completionFn(err)
+		}
+
+		return
+	}
+	l.metrics.success.Inc(1)
+
+	// Only register the completion to fire on the next flush/fsync once
+	// the write itself has actually succeeded; under PolicyIgnore or
+	// PolicyStopCommit processing continues past a failed write, so this
+	// must not be queued unconditionally up front.
+	if write.completionFn != nil {
+		l.pendingFlushFns = append(l.pendingFlushFns, l.withFlushWaitStat(stats, l.nowFn(), write.completionFn))
+	}
+}
 
-	l.closeErr <- writer.Close()
+// withFlushWaitStat wraps fn so that, when stats is non-nil, the time spent
+// waiting for the next flush/fsync to complete (StrategyWriteWait's
+// durability confirmation) is recorded before fn runs.
+func (l *commitLog) withFlushWaitStat(stats *CommitStats, queuedForFlushAt time.Time, fn completionFn) completionFn {
+	if stats == nil {
+		return fn
+	}
+
+	return func(err error) {
+		flushWait := l.nowFn().Sub(queuedForFlushAt)
+		stats.FlushWait = flushWait
+		l.metrics.statsFlushWait.Record(flushWait)
+		fn(err)
+	}
 }
 
 func (l *commitLog) onFlush(err error) {
@@ -438,56 +599,92 @@ func (l *commitLog) Write(
 	return l.writeFn(ctx, series, datapoint, unit, annotation)
 }
 
-func (l *commitLog) writeWait(
+// WriteAsync enqueues a write and returns a CommitLogAck as soon as the
+// write is enqueued rather than blocking for durability confirmation (the
+// enqueue itself may block on backpressure, see below), so that a caller can
+// batch several writes and await them together. writeWait is implemented in
+// terms of this (WriteAsync().Wait()) rather than duplicating the enqueue
+// logic with its own sync.WaitGroup.
+func (l *commitLog) WriteAsync(
 	ctx context.Context,
 	series Series,
 	datapoint ts.Datapoint,
 	unit xtime.Unit,
 	annotation ts.Annotation,
-) error {
+) (CommitLogAck, error) {
+	if err := l.healthState.getErr(); err != nil {
+		return nil, err
+	}
+
 	l.closedState.RLock()
 	if l.closedState.closed {
 		l.closedState.RUnlock()
-		return errCommitLogClosed
+		return nil, errCommitLogClosed
 	}
+	l.closedState.RUnlock()
 
-	var (
-		wg     sync.WaitGroup
-		result error
-	)
-
-	wg.Add(1)
-
-	completion := func(err error) {
-		result = err
-		wg.Done()
-	}
+	ack := newCommitLogAck()
+	stats := statsFromContext(ctx)
+	enqueueStart := l.nowFn()
 
 	write := commitLogWrite{
 		series:       series,
 		datapoint:    datapoint,
 		unit:         unit,
 		annotation:   annotation,
-		completionFn: completion,
+		completionFn: ack.complete,
+		stats:        stats,
+		enqueuedAt:   enqueueStart,
 	}
 
-	enqueued := false
+	// tryEnqueue may block indefinitely (e.g. StrategyWriteWaitUnbounded
+	// waiting for the byte cap to free up), so it must run outside the
+	// closedState critical section: holding even a read lock here would
+	// stall Close() and any other RLock acquisition for as long as this
+	// call is stalled, contradicting the brief-hold discipline documented
+	// on commitLog above.
+	enqueued := l.queue.tryEnqueue(series, write, true /* blocking */)
+	enqueueWait := l.nowFn().Sub(enqueueStart)
+	l.metrics.stallDuration.Record(enqueueWait)
+	if stats != nil {
+		stats.EnqueueWait = enqueueWait
+		l.metrics.statsEnqueueWait.Record(enqueueWait)
+	}
 
-	select {
-	case l.writes <- write:
-		enqueued = true
-	default:
+	if !enqueued {
+		ack.discard()
+		return nil, ErrCommitLogQueueFull
 	}
 
+	// Re-check closed now that the (potentially blocking) enqueue has
+	// returned: if Close() ran before the enqueue landed, the write()
+	// goroutine may have exited without draining this write, so its ack
+	// would never complete. Any close observed here returns
+	// errCommitLogClosed and no ack, including for a write that Close()'s
+	// final drain did process, since the two cases are not told apart.
+	l.closedState.RLock()
+	closed := l.closedState.closed
 	l.closedState.RUnlock()
-
-	if !enqueued {
-		return ErrCommitLogQueueFull
+	if closed {
+		return nil, errCommitLogClosed
 	}
 
-	wg.Wait()
+	return ack, nil
+}
+
+func (l *commitLog) writeWait(
+	ctx context.Context,
+	series Series,
+	datapoint ts.Datapoint,
+	unit xtime.Unit,
+	annotation ts.Annotation,
+) error {
+	ack, err := l.WriteAsync(ctx, series, datapoint, unit, annotation)
+	if err != nil {
+		return err
+	}
 
-	return result
+	return ack.Wait()
 }
 
 func (l *commitLog) writeBehind(
@@ -497,25 +694,38 @@ func (l *commitLog) writeBehind(
 	unit xtime.Unit,
 	annotation ts.Annotation,
 ) error {
+	if err := l.healthState.getErr(); err != nil {
+		return err
+	}
+
 	l.closedState.RLock()
 	if l.closedState.closed {
 		l.closedState.RUnlock()
 		return errCommitLogClosed
 	}
 
+	stats := statsFromContext(ctx)
+	enqueueStart := l.nowFn()
+
+	// Deliberately do not carry stats into the enqueued write: processWrite
+	// runs on the write() goroutine with no synchronization back to this
+	// caller under StrategyWriteBehind (unlike WriteAsync, there is no ack
+	// to wait on), so letting it write QueueWait/WriteWait into the same
+	// *CommitStats the caller may already be reading would be a data race.
 	write := commitLogWrite{
 		series:     series,
 		datapoint:  datapoint,
 		unit:       unit,
 		annotation: annotation,
+		enqueuedAt: enqueueStart,
 	}
 
-	enqueued := false
-
-	select {
-	case l.writes <- write:
-		enqueued = true
-	default:
+	enqueued := l.queue.tryEnqueue(series, write, false /* blocking */)
+	enqueueWait := l.nowFn().Sub(enqueueStart)
+	l.metrics.stallDuration.Record(enqueueWait)
+	if stats != nil {
+		stats.EnqueueWait = enqueueWait
+		l.metrics.statsEnqueueWait.Record(enqueueWait)
 	}
 
 	l.closedState.RUnlock()
@@ -535,7 +745,7 @@ func (l *commitLog) Close() error {
 	}
 
 	l.closedState.closed = true
-	close(l.writes)
+	close(l.closeCh)
 	l.closedState.Unlock()
 
 	// Receive the result of closing the writer from asynchronous writer
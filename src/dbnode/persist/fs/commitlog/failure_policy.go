@@ -0,0 +1,139 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import "sync"
+
+// CommitLogFailurePolicy determines how the commit log responds to an
+// open/write/flush error, mirroring the choice Cassandra exposes via
+// commit_failure_policy: operators can pick durability (refuse to keep
+// accepting writes once something is wrong on disk) or availability (keep
+// serving and drop/ignore the failure) depending on their deployment.
+type CommitLogFailurePolicy int
+
+const (
+	// PolicyDie fatally logs and terminates the process on any commit log
+	// error. This is the long-standing default behavior.
+	PolicyDie CommitLogFailurePolicy = iota
+	// PolicyStop stops the commit log from accepting further writes and
+	// from serving reads (e.g. ActiveLogs) once an error occurs, but lets
+	// the write() goroutine finish draining whatever was already queued;
+	// the underlying writer is only ever closed by an explicit Close()
+	// call, which still runs its normal drain/close sequence after a trip.
+	// Write (and its variants) and ActiveLogs return the sticky error from
+	// Healthy() from that point on.
+	PolicyStop
+	// PolicyStopCommit stops only the write path: Write (and its variants)
+	// short-circuit with the sticky error from Healthy(), but reads (e.g.
+	// ActiveLogs) keep working.
+	PolicyStopCommit
+	// PolicyIgnore drops the failed write (or flush) and increments an
+	// error counter, leaving the commit log otherwise healthy.
+	PolicyIgnore
+)
+
+// healthState tracks the sticky error set by the configured
+// CommitLogFailurePolicy once it has tripped, so that Healthy() and the
+// write path can be short-circuited without re-deriving the error. stopped
+// additionally distinguishes PolicyStop (which also stops reads) from
+// PolicyStopCommit (write path only). This is deliberately independent of
+// closedState: closedState.closed means "Close() has run (or is running)
+// the real drain/close sequence", and only Close() itself may set it, so
+// that a policy trip can never cause a later Close() call to take the
+// already-closed early-return branch and leak the writer/write() goroutine.
+type healthState struct {
+	sync.RWMutex
+	err     error
+	stopped bool
+}
+
+func (h *healthState) setErr(err error) {
+	h.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.Unlock()
+}
+
+func (h *healthState) setStopped(err error) {
+	h.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.stopped = true
+	h.Unlock()
+}
+
+func (h *healthState) getErr() error {
+	h.RLock()
+	err := h.err
+	h.RUnlock()
+	return err
+}
+
+func (h *healthState) isStopped() bool {
+	h.RLock()
+	stopped := h.stopped
+	h.RUnlock()
+	return stopped
+}
+
+// Healthy returns the sticky error set by the configured
+// CommitLogFailurePolicy once it has tripped (PolicyStop or
+// PolicyStopCommit), or nil if the commit log has not encountered a policy
+// trip. PolicyDie never returns because the process terminates first, and
+// PolicyIgnore never trips Healthy() since it drops individual failures
+// without affecting overall health.
+func (l *commitLog) Healthy() error {
+	return l.healthState.getErr()
+}
+
+// newCommitLogFailFn returns the commitLogFailFn matching the configured
+// CommitLogFailurePolicy, routing open/write/flush errors there instead of
+// unconditionally fatal-logging.
+func (l *commitLog) newCommitLogFailFn() commitLogFailFn {
+	switch l.opts.CommitLogFailurePolicy() {
+	case PolicyStop:
+		return l.failurePolicyStop
+	case PolicyStopCommit:
+		return l.failurePolicyStopCommit
+	case PolicyIgnore:
+		return l.failurePolicyIgnore
+	default:
+		return l.failurePolicyDie
+	}
+}
+
+func (l *commitLog) failurePolicyDie(err error) {
+	l.log.Fatalf("fatal commit log error: %v", err)
+}
+
+func (l *commitLog) failurePolicyIgnore(err error) {
+	l.metrics.policyIgnoredErrors.Inc(1)
+}
+
+func (l *commitLog) failurePolicyStopCommit(err error) {
+	l.healthState.setErr(err)
+}
+
+func (l *commitLog) failurePolicyStop(err error) {
+	l.healthState.setStopped(err)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/uber-go/tally"
+)
+
+func newTestCommitLog(policy CommitLogFailurePolicy) *commitLog {
+	l := &commitLog{
+		opts: NewOptions().SetCommitLogFailurePolicy(policy),
+		metrics: commitLogMetrics{
+			policyIgnoredErrors: tally.NoopScope.Counter("policy-ignored-errors"),
+		},
+	}
+	l.commitLogFailFn = l.newCommitLogFailFn()
+	return l
+}
+
+// TestFailurePolicyStopStopsReadsAndWrites verifies that PolicyStop trips
+// both Healthy() and ActiveLogs(), matching its documented contract that it
+// stops the commit log from serving reads once tripped.
+func TestFailurePolicyStopStopsReadsAndWrites(t *testing.T) {
+	l := newTestCommitLog(PolicyStop)
+	wantErr := errors.New("disk full")
+
+	if err := l.Healthy(); err != nil {
+		t.Fatalf("expected Healthy() to be nil before any failure, got %v", err)
+	}
+
+	l.commitLogFailFn(wantErr)
+
+	if err := l.Healthy(); err != wantErr {
+		t.Fatalf("expected Healthy() to return %v after PolicyStop trips, got %v", wantErr, err)
+	}
+
+	if _, err := l.ActiveLogs(); err != wantErr {
+		t.Fatalf("expected ActiveLogs() to return %v after PolicyStop trips, got %v", wantErr, err)
+	}
+}
+
+// TestFailurePolicyStopCommitOnlyStopsWrites verifies that PolicyStopCommit
+// trips Healthy() (which the write path short-circuits on) but leaves
+// ActiveLogs() serving reads, unlike PolicyStop.
+func TestFailurePolicyStopCommitOnlyStopsWrites(t *testing.T) {
+	l := newTestCommitLog(PolicyStopCommit)
+	wantErr := errors.New("disk full")
+
+	l.commitLogFailFn(wantErr)
+
+	if err := l.Healthy(); err != wantErr {
+		t.Fatalf("expected Healthy() to return %v after PolicyStopCommit trips, got %v", wantErr, err)
+	}
+
+	if _, err := l.ActiveLogs(); err != nil {
+		t.Fatalf("expected ActiveLogs() to keep working under PolicyStopCommit, got error %v", err)
+	}
+}
+
+// TestFailurePolicyIgnoreStaysHealthy verifies that PolicyIgnore drops the
+// failure without tripping Healthy() or ActiveLogs().
+func TestFailurePolicyIgnoreStaysHealthy(t *testing.T) {
+	l := newTestCommitLog(PolicyIgnore)
+
+	l.commitLogFailFn(errors.New("disk full"))
+
+	if err := l.Healthy(); err != nil {
+		t.Fatalf("expected Healthy() to stay nil under PolicyIgnore, got %v", err)
+	}
+
+	if _, err := l.ActiveLogs(); err != nil {
+		t.Fatalf("expected ActiveLogs() to keep working under PolicyIgnore, got error %v", err)
+	}
+}
+
+// TestFailurePolicyStopClosePolicyTripDoesNotBreakClose is a regression test
+// ensuring a PolicyStop trip no longer touches closedState: Close() must
+// still run its real drain/close sequence afterward rather than taking the
+// already-closed early-return branch.
+func TestFailurePolicyStopClosePolicyTripDoesNotBreakClose(t *testing.T) {
+	l := newTestCommitLog(PolicyStop)
+	l.commitLogFailFn(errors.New("disk full"))
+
+	l.closedState.RLock()
+	closed := l.closedState.closed
+	l.closedState.RUnlock()
+
+	if closed {
+		t.Fatal("expected a PolicyStop trip to leave closedState.closed false; only Close() may set it")
+	}
+}
+
+// TestHealthStateSetErrIsSticky verifies that the first error set on a
+// healthState wins, matching Healthy()'s documented "sticky error" contract.
+func TestHealthStateSetErrIsSticky(t *testing.T) {
+	var h healthState
+	first := errors.New("first")
+	second := errors.New("second")
+
+	h.setErr(first)
+	h.setErr(second)
+
+	if err := h.getErr(); err != first {
+		t.Fatalf("expected sticky error to remain %v, got %v", first, err)
+	}
+}
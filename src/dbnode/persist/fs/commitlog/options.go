@@ -0,0 +1,213 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"errors"
+	"time"
+
+	"github.com/m3db/m3/src/dbnode/clock"
+	"github.com/m3db/m3x/instrument"
+)
+
+const (
+	defaultFlushInterval          = time.Second
+	defaultBacklogQueueSize       = 1024
+	defaultBlockSize              = 15 * time.Minute
+	defaultStrategy               = StrategyWriteBehind
+	defaultMaxQueuedBytes         = int64(0)
+	defaultCommitLogFailurePolicy = PolicyDie
+)
+
+var errInstrumentOptionsNotSet = errors.New("instrument options not set")
+
+// Options represents the options for the commit log
+type Options interface {
+	// Validate validates the options
+	Validate() error
+
+	// SetInstrumentOptions sets the instrumentation options
+	SetInstrumentOptions(value instrument.Options) Options
+
+	// InstrumentOptions returns the instrumentation options
+	InstrumentOptions() instrument.Options
+
+	// SetClockOptions sets the clock options
+	SetClockOptions(value clock.Options) Options
+
+	// ClockOptions returns the clock options
+	ClockOptions() clock.Options
+
+	// SetStrategy sets the strategy used for writes
+	SetStrategy(value Strategy) Options
+
+	// Strategy returns the strategy used for writes
+	Strategy() Strategy
+
+	// SetFlushInterval sets the flush interval
+	SetFlushInterval(value time.Duration) Options
+
+	// FlushInterval returns the flush interval
+	FlushInterval() time.Duration
+
+	// SetBlockSize sets the block size
+	SetBlockSize(value time.Duration) Options
+
+	// BlockSize returns the block size
+	BlockSize() time.Duration
+
+	// SetBacklogQueueSize sets the backlog queue size, used to size the
+	// sharded write queue for the bounded strategies.
+	SetBacklogQueueSize(value int) Options
+
+	// BacklogQueueSize returns the backlog queue size
+	BacklogQueueSize() int
+
+	// SetMaxQueuedBytes sets the maximum number of bytes that may be queued
+	// across all pending writes under StrategyWriteWaitUnbounded and
+	// StrategyWriteBehindUnbounded before new writes backpressure (block or
+	// are rejected, respectively). Zero means unbounded.
+	SetMaxQueuedBytes(value int64) Options
+
+	// MaxQueuedBytes returns the maximum number of bytes that may be queued
+	// under the unbounded strategies.
+	MaxQueuedBytes() int64
+
+	// SetCommitLogFailurePolicy sets the policy used to respond to an
+	// open/write/flush error.
+	SetCommitLogFailurePolicy(value CommitLogFailurePolicy) Options
+
+	// CommitLogFailurePolicy returns the policy used to respond to an
+	// open/write/flush error.
+	CommitLogFailurePolicy() CommitLogFailurePolicy
+}
+
+type options struct {
+	instrumentOpts   instrument.Options
+	clockOpts        clock.Options
+	strategy         Strategy
+	flushInterval    time.Duration
+	blockSize        time.Duration
+	backlogQueueSize int
+	maxQueuedBytes   int64
+	failurePolicy    CommitLogFailurePolicy
+}
+
+// NewOptions creates new options with default values
+func NewOptions() Options {
+	return &options{
+		instrumentOpts:   instrument.NewOptions(),
+		clockOpts:        clock.NewOptions(),
+		strategy:         defaultStrategy,
+		flushInterval:    defaultFlushInterval,
+		blockSize:        defaultBlockSize,
+		backlogQueueSize: defaultBacklogQueueSize,
+		maxQueuedBytes:   defaultMaxQueuedBytes,
+		failurePolicy:    defaultCommitLogFailurePolicy,
+	}
+}
+
+func (o *options) Validate() error {
+	if o.instrumentOpts == nil {
+		return errInstrumentOptionsNotSet
+	}
+	return nil
+}
+
+func (o *options) SetInstrumentOptions(value instrument.Options) Options {
+	opts := *o
+	opts.instrumentOpts = value
+	return &opts
+}
+
+func (o *options) InstrumentOptions() instrument.Options {
+	return o.instrumentOpts
+}
+
+func (o *options) SetClockOptions(value clock.Options) Options {
+	opts := *o
+	opts.clockOpts = value
+	return &opts
+}
+
+func (o *options) ClockOptions() clock.Options {
+	return o.clockOpts
+}
+
+func (o *options) SetStrategy(value Strategy) Options {
+	opts := *o
+	opts.strategy = value
+	return &opts
+}
+
+func (o *options) Strategy() Strategy {
+	return o.strategy
+}
+
+func (o *options) SetFlushInterval(value time.Duration) Options {
+	opts := *o
+	opts.flushInterval = value
+	return &opts
+}
+
+func (o *options) FlushInterval() time.Duration {
+	return o.flushInterval
+}
+
+func (o *options) SetBlockSize(value time.Duration) Options {
+	opts := *o
+	opts.blockSize = value
+	return &opts
+}
+
+func (o *options) BlockSize() time.Duration {
+	return o.blockSize
+}
+
+func (o *options) SetBacklogQueueSize(value int) Options {
+	opts := *o
+	opts.backlogQueueSize = value
+	return &opts
+}
+
+func (o *options) BacklogQueueSize() int {
+	return o.backlogQueueSize
+}
+
+func (o *options) SetMaxQueuedBytes(value int64) Options {
+	opts := *o
+	opts.maxQueuedBytes = value
+	return &opts
+}
+
+func (o *options) MaxQueuedBytes() int64 {
+	return o.maxQueuedBytes
+}
+
+func (o *options) SetCommitLogFailurePolicy(value CommitLogFailurePolicy) Options {
+	opts := *o
+	opts.failurePolicy = value
+	return &opts
+}
+
+func (o *options) CommitLogFailurePolicy() CommitLogFailurePolicy {
+	return o.failurePolicy
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	stdcontext "context"
+	"time"
+
+	"github.com/m3db/m3x/context"
+)
+
+// CommitStats breaks a single write's latency down by stage, so that P99
+// write latency can be attributed to a specific part of the pipeline instead
+// of guessed at. It is opt-in: callers that don't attach one via WithStats
+// pay only the context lookup on the write path, and no per-stage timing is
+// recorded for their writes.
+//
+// CommitStats is only safe to read once the caller has synchronized with
+// the write it was attached to, e.g. after Write (StrategyWriteWait) returns
+// or after waiting on the CommitLogAck from WriteAsync. QueueWait and
+// WriteWait are populated by the write() goroutine with no happens-before
+// edge back to a caller of Write under the behind strategies, so CommitStats
+// passed to such a Write is deliberately left untouched past EnqueueWait
+// rather than risk a caller observing a torn or zero value; see writeBehind.
+// WriteAsync populates every stage whatever the configured strategy.
+type CommitStats struct {
+	// EnqueueWait is the time spent in the call that enqueues the write,
+	// including any time spent blocked on backpressure.
+	EnqueueWait time.Duration
+	// QueueWait is the time elapsed between the write being submitted and
+	// write() dequeuing it. Not populated by Write under the behind
+	// strategies.
+	QueueWait time.Duration
+	// WriteWait is the time spent inside the underlying writer's Write call.
+	// Not populated by Write under the behind strategies.
+	WriteWait time.Duration
+	// FlushWait is the time spent waiting for the next flush/fsync to
+	// complete before the write's ack fires. Only populated for writes made
+	// through WriteAsync (which Write uses under StrategyWriteWait and its
+	// unbounded variant); zero for Write under the behind strategies, which
+	// never waits on a flush.
+	FlushWait time.Duration
+}
+
+type commitStatsContextKey struct{}
+
+// WithStats attaches stats to ctx so that CommitLog.Write/WriteAsync records
+// per-stage latency into it. The hot path checks for this opt-in on every
+// write, so callers that don't need the breakdown should not attach one.
+func WithStats(ctx context.Context, stats *CommitStats) context.Context {
+	goCtx, ok := ctx.GoContext()
+	if !ok {
+		goCtx = stdcontext.Background()
+	}
+	ctx.SetGoContext(stdcontext.WithValue(goCtx, commitStatsContextKey{}, stats))
+	return ctx
+}
+
+// statsFromContext returns the CommitStats attached via WithStats, or nil if
+// none was attached.
+func statsFromContext(ctx context.Context) *CommitStats {
+	goCtx, ok := ctx.GoContext()
+	if !ok {
+		return nil
+	}
+	stats, _ := goCtx.Value(commitStatsContextKey{}).(*CommitStats)
+	return stats
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/dbnode/ts"
+	"github.com/m3db/m3x/context"
+	xtime "github.com/m3db/m3x/time"
+
+	"github.com/uber-go/tally"
+)
+
+func TestStatsFromContextNoneAttached(t *testing.T) {
+	ctx := context.NewContext()
+	if stats := statsFromContext(ctx); stats != nil {
+		t.Fatalf("expected nil stats when none attached, got %+v", stats)
+	}
+}
+
+func TestWithStatsRoundTrip(t *testing.T) {
+	ctx := context.NewContext()
+	want := &CommitStats{}
+
+	ctx = WithStats(ctx, want)
+
+	got := statsFromContext(ctx)
+	if got != want {
+		t.Fatalf("expected statsFromContext to return the same *CommitStats pointer attached via WithStats")
+	}
+}
+
+// TestWithStatsPopulatedByWriteWait enqueues a write through WriteAsync,
+// stands in for processWrite by setting QueueWait and WriteWait from the
+// drain callback (no real writer is involved, so FlushWait is not covered),
+// and asserts those values are visible once the ack has been waited on,
+// i.e. that the ack gives the caller a happens-before edge back to the
+// write() goroutine's writes.
+func TestWithStatsPopulatedByWriteWait(t *testing.T) {
+	l := &commitLog{
+		opts:  NewOptions(),
+		nowFn: time.Now,
+		queue: newShardedWriteQueue(1, defaultWriteQueueShardRingSize),
+		metrics: commitLogMetrics{
+			stallDuration:    tally.NoopScope.Timer("stall-duration"),
+			statsEnqueueWait: tally.NoopScope.Timer("stats-enqueue-wait"),
+		},
+	}
+
+	stats := &CommitStats{}
+	ctx := WithStats(context.NewContext(), stats)
+
+	ack, err := l.WriteAsync(ctx, Series{}, ts.Datapoint{}, xtime.Unit(0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error from WriteAsync: %v", err)
+	}
+
+	// Simulate the write() goroutine dequeuing and completing the write,
+	// as processWrite would after a successful writer.Write.
+	l.queue.drainAll(func(w commitLogWrite) {
+		stats.QueueWait = time.Millisecond
+		stats.WriteWait = time.Millisecond
+		w.completionFn(nil)
+	})
+
+	if err := ack.Wait(); err != nil {
+		t.Fatalf("unexpected error from ack.Wait(): %v", err)
+	}
+
+	if stats.QueueWait == 0 {
+		t.Fatal("expected QueueWait to be populated once the ack has been waited on")
+	}
+	if stats.WriteWait == 0 {
+		t.Fatal("expected WriteWait to be populated once the ack has been waited on")
+	}
+}
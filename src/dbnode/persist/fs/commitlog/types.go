@@ -0,0 +1,80 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"github.com/m3db/m3/src/dbnode/ts"
+	"github.com/m3db/m3x/context"
+	xtime "github.com/m3db/m3x/time"
+)
+
+// Strategy describes the commit log writing strategy
+type Strategy int
+
+const (
+	// StrategyWriteWait describes the strategy that waits
+	// for the commit log to ack a write before returning from
+	// a Write()/WriteAsync().Wait() call
+	StrategyWriteWait Strategy = iota
+
+	// StrategyWriteBehind describes the strategy that does not wait
+	// for the commit log to ack a write before returning from
+	// a Write() call
+	StrategyWriteBehind
+)
+
+// CommitLog provides a synchronized commit log
+type CommitLog interface {
+	// Open the commit log
+	Open() error
+
+	// Write will write an entry in the commit log for a given series
+	Write(
+		ctx context.Context,
+		series Series,
+		datapoint ts.Datapoint,
+		unit xtime.Unit,
+		annotation ts.Annotation,
+	) error
+
+	// WriteAsync writes an entry in the commit log without waiting for it to
+	// be durably flushed, returning a CommitLogAck the caller can use to
+	// confirm durability (or failure) later.
+	WriteAsync(
+		ctx context.Context,
+		series Series,
+		datapoint ts.Datapoint,
+		unit xtime.Unit,
+		annotation ts.Annotation,
+	) (CommitLogAck, error)
+
+	// ActiveLogs returns the list of commit log files that are still active
+	ActiveLogs() ([]File, error)
+
+	// Healthy returns the sticky error set by the configured
+	// CommitLogFailurePolicy once it has tripped (PolicyStop or
+	// PolicyStopCommit), or nil if the commit log has not encountered a
+	// policy trip.
+	Healthy() error
+
+	// Close the commit log
+	Close() error
+}
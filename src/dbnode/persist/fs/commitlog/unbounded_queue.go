@@ -0,0 +1,162 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import "sync"
+
+// unboundedWriteBatchSize is the number of writes accumulated into a single
+// batch node of the unbounded queue's linked chain before a new node is
+// allocated.
+const unboundedWriteBatchSize = 128
+
+// commitLogWriteOverheadBytes approximates the fixed per-write overhead (series
+// identifiers, datapoint, unit) that isn't captured by the variable-length
+// annotation, for the purposes of accounting against MaxQueuedBytes().
+const commitLogWriteOverheadBytes = 64
+
+// unboundedWriteQueue backs StrategyWriteWaitUnbounded and
+// StrategyWriteBehindUnbounded. Rather than a fixed-size ring that rejects
+// writes outright once full, it keeps an unbounded linked chain of
+// fixed-size batches and only pushes back once the approximate memory
+// footprint of everything queued exceeds Options.MaxQueuedBytes(), so that a
+// transient fsync stall doesn't immediately starve the write path.
+type unboundedWriteQueue struct {
+	mu      sync.Mutex
+	notFull *sync.Cond
+
+	head *writeBatchNode
+	tail *writeBatchNode
+
+	queuedWrites int
+	queuedBytes  int64
+
+	maxQueuedBytes int64
+
+	wakeCh chan struct{}
+}
+
+type writeBatchNode struct {
+	writes []commitLogWrite
+	next   *writeBatchNode
+}
+
+func newUnboundedWriteQueue(maxQueuedBytes int64) *unboundedWriteQueue {
+	q := &unboundedWriteQueue{
+		maxQueuedBytes: maxQueuedBytes,
+		wakeCh:         make(chan struct{}, 1),
+	}
+	q.notFull = sync.NewCond(&q.mu)
+
+	node := &writeBatchNode{writes: make([]commitLogWrite, 0, unboundedWriteBatchSize)}
+	q.head, q.tail = node, node
+
+	return q
+}
+
+// push appends write to the tail of the chain. If enqueueing it would push
+// queuedBytes past maxQueuedBytes, push either blocks until room is freed by
+// a drain (when blocking is true, used by the wait strategy) or returns
+// false immediately (used by the behind strategy, which never blocks).
+func (q *unboundedWriteQueue) push(write commitLogWrite, size int64, blocking bool) bool {
+	q.mu.Lock()
+
+	for q.maxQueuedBytes > 0 && q.queuedBytes+size > q.maxQueuedBytes {
+		if !blocking {
+			q.mu.Unlock()
+			return false
+		}
+		q.notFull.Wait()
+	}
+
+	if len(q.tail.writes) == cap(q.tail.writes) {
+		next := &writeBatchNode{writes: make([]commitLogWrite, 0, unboundedWriteBatchSize)}
+		q.tail.next = next
+		q.tail = next
+	}
+	q.tail.writes = append(q.tail.writes, write)
+	q.queuedWrites++
+	q.queuedBytes += size
+
+	q.mu.Unlock()
+
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// drainAll detaches every batch currently queued, wakes any producers
+// blocked in push waiting for room to free up, and then invokes fn for each
+// detached write in enqueue order.
+func (q *unboundedWriteQueue) drainAll(fn func(commitLogWrite)) {
+	q.mu.Lock()
+	head := q.head
+	empty := &writeBatchNode{writes: make([]commitLogWrite, 0, unboundedWriteBatchSize)}
+	q.head, q.tail = empty, empty
+	q.queuedWrites = 0
+	q.queuedBytes = 0
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+
+	for node := head; node != nil; node = node.next {
+		for _, write := range node.writes {
+			fn(write)
+		}
+	}
+}
+
+func (q *unboundedWriteQueue) len() int {
+	q.mu.Lock()
+	n := q.queuedWrites
+	q.mu.Unlock()
+	return n
+}
+
+func (q *unboundedWriteQueue) bytes() int64 {
+	q.mu.Lock()
+	b := q.queuedBytes
+	q.mu.Unlock()
+	return b
+}
+
+// cap implements writeQueue. The chain is unbounded in length; -1 signals
+// there is no fixed slot capacity to report.
+func (q *unboundedWriteQueue) cap() int {
+	return -1
+}
+
+// tryEnqueue implements writeQueue, ignoring series since the unbounded
+// queue is a single FIFO chain rather than sharded by series.
+func (q *unboundedWriteQueue) tryEnqueue(_ Series, write commitLogWrite, blocking bool) bool {
+	return q.push(write, approxWriteSize(write), blocking)
+}
+
+func (q *unboundedWriteQueue) wake() <-chan struct{} {
+	return q.wakeCh
+}
+
+// approxWriteSize estimates the memory footprint of a single queued write
+// for the purposes of accounting against MaxQueuedBytes().
+func approxWriteSize(write commitLogWrite) int64 {
+	return int64(commitLogWriteOverheadBytes + len(write.annotation))
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnboundedWriteQueueNonBlockingRejectsOverCap(t *testing.T) {
+	write := commitLogWrite{}
+	maxQueuedBytes := approxWriteSize(write)
+
+	q := newUnboundedWriteQueue(maxQueuedBytes)
+
+	if !q.push(write, approxWriteSize(write), false /* blocking */) {
+		t.Fatal("expected first push to succeed, queue is empty")
+	}
+
+	if q.push(write, approxWriteSize(write), false /* blocking */) {
+		t.Fatal("expected second push to be rejected, maxQueuedBytes exceeded")
+	}
+
+	if n := q.len(); n != 1 {
+		t.Fatalf("expected len 1 after rejected push, got %d", n)
+	}
+	if b := q.bytes(); b != maxQueuedBytes {
+		t.Fatalf("expected bytes %d after rejected push, got %d", maxQueuedBytes, b)
+	}
+}
+
+func TestUnboundedWriteQueueZeroMaxQueuedBytesIsUnbounded(t *testing.T) {
+	q := newUnboundedWriteQueue(0)
+
+	for i := 0; i < 10000; i++ {
+		if !q.push(commitLogWrite{}, approxWriteSize(commitLogWrite{}), false /* blocking */) {
+			t.Fatalf("push %d: expected unbounded queue (maxQueuedBytes=0) to never reject", i)
+		}
+	}
+
+	if n := q.len(); n != 10000 {
+		t.Fatalf("expected len 10000, got %d", n)
+	}
+}
+
+// TestUnboundedWriteQueueBlockingPushWakesOnDrain verifies that a push
+// blocked on notFull.Wait() because the byte cap is exceeded is woken up by
+// drainAll's Broadcast, matching the sync.Cond handshake the byte-based
+// backpressure for StrategyWriteWaitUnbounded depends on.
+func TestUnboundedWriteQueueBlockingPushWakesOnDrain(t *testing.T) {
+	write := commitLogWrite{}
+	maxQueuedBytes := approxWriteSize(write)
+
+	q := newUnboundedWriteQueue(maxQueuedBytes)
+
+	if !q.push(write, approxWriteSize(write), false /* blocking */) {
+		t.Fatal("expected first push to succeed, queue is empty")
+	}
+
+	blockedPushDone := make(chan bool, 1)
+	go func() {
+		blockedPushDone <- q.push(write, approxWriteSize(write), true /* blocking */)
+	}()
+
+	select {
+	case <-blockedPushDone:
+		t.Fatal("expected blocking push to stay blocked until drainAll frees room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	drained := 0
+	q.drainAll(func(commitLogWrite) { drained++ })
+	if drained != 1 {
+		t.Fatalf("expected drainAll to observe the one write enqueued before the blocked push, got %d", drained)
+	}
+
+	select {
+	case ok := <-blockedPushDone:
+		if !ok {
+			t.Fatal("expected blocking push to eventually succeed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocking push was never woken by drainAll's Broadcast")
+	}
+
+	if n := q.len(); n != 1 {
+		t.Fatalf("expected len 1 after the blocked push lands, got %d", n)
+	}
+}
+
+func TestUnboundedWriteQueueDrainAllOrderAndMultipleBatches(t *testing.T) {
+	q := newUnboundedWriteQueue(0)
+
+	const numWrites = unboundedWriteBatchSize*2 + 3
+	for i := 0; i < numWrites; i++ {
+		w := commitLogWrite{enqueuedAt: time.Unix(int64(i), 0)}
+		if !q.push(w, approxWriteSize(w), false /* blocking */) {
+			t.Fatalf("push %d: unexpected rejection from an unbounded (maxQueuedBytes=0) queue", i)
+		}
+	}
+
+	var next int64
+	q.drainAll(func(w commitLogWrite) {
+		if got := w.enqueuedAt.Unix(); got != next {
+			t.Fatalf("out of order drain: expected %d, got %d", next, got)
+		}
+		next++
+	})
+
+	if next != numWrites {
+		t.Fatalf("expected to observe %d writes across multiple batch nodes, got %d", numWrites, next)
+	}
+	if n := q.len(); n != 0 {
+		t.Fatalf("expected queue to be empty after drainAll, got len %d", n)
+	}
+	if b := q.bytes(); b != 0 {
+		t.Fatalf("expected queuedBytes to be reset to 0 after drainAll, got %d", b)
+	}
+}
+
+func TestUnboundedWriteQueueCapReportsUnbounded(t *testing.T) {
+	q := newUnboundedWriteQueue(0)
+	if c := q.cap(); c != -1 {
+		t.Fatalf("expected cap() to report -1 (unbounded), got %d", c)
+	}
+}
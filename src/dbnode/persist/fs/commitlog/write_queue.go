@@ -0,0 +1,198 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import "sync/atomic"
+
+// defaultWriteQueueShardRingSize is the minimum number of slots allocated to
+// each shard's ring. It is rounded up to the nearest power of two so that
+// ring indices can be masked rather than modulo'd.
+const defaultWriteQueueShardRingSize = 128
+
+// shardedWriteQueue replaces a single central buffered channel with N
+// lock-free multi-producer, single-consumer rings striped by series, one per shard,
+// so that concurrent writers for different series no longer contend on a
+// single channel's internal lock. Writers CAS-reserve a slot in their shard's
+// ring and fill it in place; the single write() goroutine rotates across
+// shards draining whatever is ready. Per-series ordering is preserved because
+// a given series always hashes to the same shard and slots within a shard
+// are only ever drained in order.
+type shardedWriteQueue struct {
+	shards []*writeQueueShard
+	mask   uint64
+
+	// wakeCh is signalled (non-blocking, coalesced) whenever a write is
+	// enqueued so that write() can block instead of busy-polling the shards.
+	wakeCh chan struct{}
+}
+
+type writeQueueShard struct {
+	ringMask uint64
+	ring     []writeQueueSlot
+
+	// head is the next ring index a producer will attempt to CAS-reserve.
+	head uint64
+	// tail is the next ring index the single consumer will drain from.
+	tail uint64
+}
+
+type writeQueueSlot struct {
+	// ready is set after the reserving producer has finished populating
+	// write, so that the consumer never observes a torn write.
+	ready uint32
+	write commitLogWrite
+}
+
+func newShardedWriteQueue(numShards int, backlogQueueSize int) *shardedWriteQueue {
+	if numShards < 1 {
+		numShards = 1
+	}
+	numShards = nextPowerOfTwo(numShards)
+
+	ringSize := nextPowerOfTwo(backlogQueueSize / numShards)
+	if ringSize < defaultWriteQueueShardRingSize {
+		ringSize = defaultWriteQueueShardRingSize
+	}
+
+	shards := make([]*writeQueueShard, numShards)
+	for i := range shards {
+		shards[i] = &writeQueueShard{
+			ringMask: uint64(ringSize - 1),
+			ring:     make([]writeQueueSlot, ringSize),
+		}
+	}
+
+	return &shardedWriteQueue{
+		shards: shards,
+		mask:   uint64(numShards - 1),
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// shardFor hashes the series to a stable shard so that all writes for a
+// given series are drained in enqueue order.
+func (q *shardedWriteQueue) shardFor(series Series) *writeQueueShard {
+	return q.shards[series.UniqueIndex&q.mask]
+}
+
+// push attempts to enqueue write onto the shard owned by series. It returns
+// false if that shard's ring is full, mirroring the previous behavior of the
+// buffered channel being full.
+func (q *shardedWriteQueue) push(series Series, write commitLogWrite) bool {
+	if !q.shardFor(series).tryPush(write) {
+		return false
+	}
+
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+func (s *writeQueueShard) tryPush(write commitLogWrite) bool {
+	for {
+		head := atomic.LoadUint64(&s.head)
+		tail := atomic.LoadUint64(&s.tail)
+		if head-tail > s.ringMask {
+			// Ring is full, reservation would lap the consumer.
+			return false
+		}
+
+		if atomic.CompareAndSwapUint64(&s.head, head, head+1) {
+			slot := &s.ring[head&s.ringMask]
+			slot.write = write
+			atomic.StoreUint32(&slot.ready, 1)
+			return true
+		}
+	}
+}
+
+// drainAll rotates across every shard, draining whatever contiguous run of
+// ready slots is available in each, and invokes fn for every write popped.
+func (q *shardedWriteQueue) drainAll(fn func(commitLogWrite)) {
+	for _, shard := range q.shards {
+		shard.drain(fn)
+	}
+}
+
+func (s *writeQueueShard) drain(fn func(commitLogWrite)) {
+	for {
+		tail := atomic.LoadUint64(&s.tail)
+		if tail == atomic.LoadUint64(&s.head) {
+			return
+		}
+
+		slot := &s.ring[tail&s.ringMask]
+		if !atomic.CompareAndSwapUint32(&slot.ready, 1, 0) {
+			// Reserved but the producer hasn't finished writing yet, stop
+			// draining this shard until the next rotation.
+			return
+		}
+
+		write := slot.write
+		slot.write = commitLogWrite{}
+		atomic.StoreUint64(&s.tail, tail+1)
+		fn(write)
+	}
+}
+
+func (q *shardedWriteQueue) len() int {
+	var n int
+	for _, shard := range q.shards {
+		n += int(atomic.LoadUint64(&shard.head) - atomic.LoadUint64(&shard.tail))
+	}
+	return n
+}
+
+func (q *shardedWriteQueue) cap() int {
+	var n int
+	for _, shard := range q.shards {
+		n += len(shard.ring)
+	}
+	return n
+}
+
+// tryEnqueue implements writeQueue. The ring-based queue never blocks
+// regardless of the blocking argument: its capacity is fixed, so a caller
+// either reserves a slot immediately or is told the queue is full.
+func (q *shardedWriteQueue) tryEnqueue(series Series, write commitLogWrite, blocking bool) bool {
+	return q.push(series, write)
+}
+
+func (q *shardedWriteQueue) wake() <-chan struct{} {
+	return q.wakeCh
+}
+
+// bytes implements writeQueue. The sharded ring's capacity is already
+// bounded by its fixed slot count, so it does not track byte usage.
+func (q *shardedWriteQueue) bytes() int64 {
+	return 0
+}